@@ -3,7 +3,10 @@ package cookie_domain_rewrite
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"path"
 	"regexp"
 	"strings"
 )
@@ -12,12 +15,81 @@ import (
 type Config struct {
 	MatchDomains []string            `json:"matchDomains,omitempty"`
 	Replacements []DomainReplacement `json:"replacements,omitempty"`
+
+	// MatchRegistrableDomain makes each MatchDomains entry without a "*"
+	// also match any host that shares its registrable domain (per
+	// PublicSuffixList); wildcard entries still match only by pattern. So
+	// "oreilly.local" matches "api.oreilly.local" and "www.oreilly.local"
+	// without needing a "*.oreilly.local" wildcard.
+	MatchRegistrableDomain bool `json:"matchRegistrableDomain,omitempty"`
+
+	// PublicSuffixList supplies the algorithm used to compute registrable
+	// domains for MatchRegistrableDomain and for FromSuffix/ToSuffix
+	// replacement rules. It is not JSON-configurable; callers building a
+	// Config in Go can plug in golang.org/x/net/publicsuffix.List or any
+	// other implementation. When nil, an embedded fallback list is used.
+	PublicSuffixList PublicSuffixList `json:"-"`
+
+	// RewriteRequest, when true, also rewrites the outgoing Host, Origin
+	// and Referer using the reverse of each replacement (To back to From)
+	// before forwarding the request. This is for dev proxies where the
+	// browser talks to the replacement's To domain but the upstream only
+	// recognizes From.
+	RewriteRequest bool `json:"rewriteRequest,omitempty"`
+
+	// Buffered, when true, captures the whole response (status, headers and
+	// body) before writing anything to the real ResponseWriter, instead of
+	// rewriting Set-Cookie headers as soon as the handler first calls
+	// WriteHeader/Write. This matters when this middleware is chained ahead
+	// of others (e.g. compression, caching) whose ordering depends on
+	// seeing the final header set rather than a partial one.
+	Buffered bool `json:"buffered,omitempty"`
+
+	// Logger receives one debug-level structured log entry per rewritten
+	// cookie. Neither is JSON-configurable; callers building a Config in Go
+	// set these directly. When Logger is nil, nothing is logged.
+	Logger *slog.Logger `json:"-"`
+
+	// RewriteCounter and SkipCounter, when set, are incremented for each
+	// rewritten cookie (labeled rule index, from domain, to domain) and
+	// each cookie left untouched (labeled with a skip reason), letting
+	// operators expose cookie_rewrites_total{rule,from,to} and
+	// cookie_rewrite_skipped_total{reason} without this plugin depending on
+	// the Prometheus client library directly.
+	RewriteCounter CounterVec `json:"-"`
+	SkipCounter    CounterVec `json:"-"`
 }
 
-// DomainReplacement defines a domain substitution rule
+// DomainReplacement defines a domain substitution rule, plus the other
+// Set-Cookie attributes that commonly need to move in lockstep with it when
+// a cookie crosses from one environment to another.
 type DomainReplacement struct {
 	From string `json:"from"`
 	To   string `json:"to"`
+
+	// PathFrom and PathTo rewrite the cookie's Path attribute the same way
+	// From/To rewrite its Domain attribute: the PathFrom prefix is swapped
+	// for PathTo. Both must be set for path rewriting to apply.
+	PathFrom string `json:"pathFrom,omitempty"`
+	PathTo   string `json:"pathTo,omitempty"`
+
+	// SameSite, when set, overwrites the cookie's SameSite attribute, e.g.
+	// to downgrade "None" to "Lax" for a dev target that can't satisfy the
+	// cross-site requirements "None" implies.
+	SameSite string `json:"sameSite,omitempty"`
+
+	// StripSecure removes the Secure flag, for dev targets served over
+	// plain HTTP that would otherwise have the cookie silently dropped.
+	StripSecure bool `json:"stripSecure,omitempty"`
+
+	// FromSuffix and ToSuffix match and rewrite by registrable domain
+	// instead of exact value: a cookie's Domain attribute is rewritten when
+	// its registrable domain (per Config.PublicSuffixList) equals
+	// FromSuffix, with any subdomain labels preserved and only the
+	// registrable-domain part swapped for ToSuffix. Takes effect only when
+	// From is empty, so a rule is either exact-match or suffix-match.
+	FromSuffix string `json:"fromSuffix,omitempty"`
+	ToSuffix   string `json:"toSuffix,omitempty"`
 }
 
 // CreateConfig creates the default plugin configuration
@@ -35,10 +107,16 @@ func CreateConfig() *Config {
 
 // CookieDomainRewriter is the middleware struct
 type CookieDomainRewriter struct {
-	next         http.Handler
-	name         string
-	matchDomains []*regexp.Regexp
-	replacements []DomainReplacement
+	next                   http.Handler
+	name                   string
+	matchDomains           []*regexp.Regexp
+	rawMatchDomains        []string
+	matchRegistrableDomain bool
+	publicSuffixList       PublicSuffixList
+	replacements           []DomainReplacement
+	rewriteRequest         bool
+	buffered               bool
+	observer               observer
 }
 
 // New creates a new CookieDomainRewriter plugin
@@ -53,7 +131,7 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 		// Convert wildcard pattern to regex
 		regexPattern := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, `.*`)
 		regexPattern = "^" + regexPattern + "$"
-		
+
 		re, err := regexp.Compile(regexPattern)
 		if err != nil {
 			return nil, fmt.Errorf("invalid match domain pattern '%s': %w", pattern, err)
@@ -61,11 +139,26 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 		matchers = append(matchers, re)
 	}
 
+	psl := config.PublicSuffixList
+	if psl == nil {
+		psl = defaultPublicSuffixList{}
+	}
+
 	return &CookieDomainRewriter{
-		next:         next,
-		name:         name,
-		matchDomains: matchers,
-		replacements: config.Replacements,
+		next:                   next,
+		name:                   name,
+		matchDomains:           matchers,
+		rawMatchDomains:        config.MatchDomains,
+		matchRegistrableDomain: config.MatchRegistrableDomain,
+		publicSuffixList:       psl,
+		replacements:           config.Replacements,
+		rewriteRequest:         config.RewriteRequest,
+		buffered:               config.Buffered,
+		observer: observer{
+			logger:         config.Logger,
+			rewriteCounter: config.RewriteCounter,
+			skipCounter:    config.SkipCounter,
+		},
 	}, nil
 }
 
@@ -80,10 +173,32 @@ func (c *CookieDomainRewriter) ServeHTTP(rw http.ResponseWriter, req *http.Reque
 		return
 	}
 
+	if c.rewriteRequest {
+		c.rewriteRequestHeaders(req)
+	}
+
+	requestHost := hostWithoutPort(req.Host)
+
+	if c.buffered {
+		wrappedWriter := &bufferedResponseWriter{
+			ResponseWriter:   rw,
+			replacements:     c.replacements,
+			requestHost:      requestHost,
+			publicSuffixList: c.publicSuffixList,
+			observer:         c.observer,
+		}
+		c.next.ServeHTTP(wrappedWriter, req)
+		wrappedWriter.finalize()
+		return
+	}
+
 	// Wrap the response writer to intercept Set-Cookie headers
 	wrappedWriter := &responseWriter{
-		ResponseWriter: rw,
-		replacements:   c.replacements,
+		ResponseWriter:   rw,
+		replacements:     c.replacements,
+		requestHost:      requestHost,
+		publicSuffixList: c.publicSuffixList,
+		observer:         c.observer,
 	}
 
 	c.next.ServeHTTP(wrappedWriter, req)
@@ -94,12 +209,7 @@ func (c *CookieDomainRewriter) shouldRewriteForRequest(req *http.Request) bool {
 	// Check Host header (includes :authority for HTTP/2)
 	host := req.Host
 	if host != "" {
-		// Strip port if present
-		if colonIdx := strings.LastIndex(host, ":"); colonIdx != -1 {
-			host = host[:colonIdx]
-		}
-		
-		if c.matchesDomain(host) {
+		if c.matchesDomain(hostWithoutPort(host)) {
 			return true
 		}
 	}
@@ -113,14 +223,14 @@ func (c *CookieDomainRewriter) shouldRewriteForRequest(req *http.Request) bool {
 		} else if strings.HasPrefix(origin, "https://") {
 			origin = origin[8:]
 		}
-		
+
 		if colonIdx := strings.Index(origin, ":"); colonIdx != -1 {
 			origin = origin[:colonIdx]
 		}
 		if slashIdx := strings.Index(origin, "/"); slashIdx != -1 {
 			origin = origin[:slashIdx]
 		}
-		
+
 		if c.matchesDomain(origin) {
 			return true
 		}
@@ -135,14 +245,14 @@ func (c *CookieDomainRewriter) shouldRewriteForRequest(req *http.Request) bool {
 		} else if strings.HasPrefix(referer, "https://") {
 			referer = referer[8:]
 		}
-		
+
 		if colonIdx := strings.Index(referer, ":"); colonIdx != -1 {
 			referer = referer[:colonIdx]
 		}
 		if slashIdx := strings.Index(referer, "/"); slashIdx != -1 {
 			referer = referer[:slashIdx]
 		}
-		
+
 		if c.matchesDomain(referer) {
 			return true
 		}
@@ -158,61 +268,319 @@ func (c *CookieDomainRewriter) matchesDomain(hostname string) bool {
 			return true
 		}
 	}
+
+	if !c.matchRegistrableDomain {
+		return false
+	}
+
+	target := registrableDomain(hostname, c.publicSuffixList)
+	for _, pattern := range c.rawMatchDomains {
+		if strings.Contains(pattern, "*") {
+			continue // glob patterns are handled by matchDomains above
+		}
+		if strings.EqualFold(registrableDomain(pattern, c.publicSuffixList), target) {
+			return true
+		}
+	}
 	return false
 }
 
-// responseWriter wraps http.ResponseWriter to intercept Set-Cookie headers
-type responseWriter struct {
-	http.ResponseWriter
-	replacements []DomainReplacement
-	wroteHeader  bool
+// hostWithoutPort strips a trailing ":port" from a Host header value.
+func hostWithoutPort(host string) string {
+	if colonIdx := strings.LastIndex(host, ":"); colonIdx != -1 {
+		return host[:colonIdx]
+	}
+	return host
+}
+
+// portOf returns the port from a Host header value's ":port" suffix, or ""
+// if there is none.
+func portOf(host string) string {
+	if colonIdx := strings.LastIndex(host, ":"); colonIdx != -1 {
+		return host[colonIdx+1:]
+	}
+	return ""
+}
+
+// rewriteRequestHeaders applies the reverse of each replacement (To back to
+// From) to the outgoing Host, Origin and Referer, so a request the browser
+// sent to the replacement's To domain reaches an upstream that still
+// expects From. The Cookie header (RFC 6265 §5.4) is just a list of
+// name=value pairs with no Domain attribute, so there is nothing in it to
+// reverse-rewrite — the browser already decided which cookies to send based
+// on the domain it requested.
+func (c *CookieDomainRewriter) rewriteRequestHeaders(req *http.Request) {
+	reversed := reverseReplacements(c.replacements)
+
+	if host := hostWithoutPort(req.Host); host != "" {
+		if _, _, replaced, ok := matchDomain(host, reversed, c.publicSuffixList); ok {
+			if port := portOf(req.Host); port != "" {
+				req.Host = replaced + ":" + port
+			} else {
+				req.Host = replaced
+			}
+		}
+	}
+
+	for _, header := range []string{"Origin", "Referer"} {
+		if value := req.Header.Get(header); value != "" {
+			req.Header.Set(header, rewriteHostInURL(value, reversed, c.publicSuffixList))
+		}
+	}
 }
 
-// WriteHeader intercepts the header write to modify Set-Cookie headers
-func (r *responseWriter) WriteHeader(statusCode int) {
-	if !r.wroteHeader {
-		r.rewriteCookieDomains()
-		r.wroteHeader = true
+// reverseReplacements swaps From/To and FromSuffix/ToSuffix on each rule so
+// matchDomain can be reused to translate a replacement's To domain back to
+// its From.
+func reverseReplacements(replacements []DomainReplacement) []DomainReplacement {
+	reversed := make([]DomainReplacement, len(replacements))
+	for i, r := range replacements {
+		reversed[i] = DomainReplacement{
+			From:       r.To,
+			To:         r.From,
+			FromSuffix: r.ToSuffix,
+			ToSuffix:   r.FromSuffix,
+		}
 	}
-	r.ResponseWriter.WriteHeader(statusCode)
+	return reversed
 }
 
-// Write ensures headers are written before body
-func (r *responseWriter) Write(b []byte) (int, error) {
-	if !r.wroteHeader {
-		r.rewriteCookieDomains()
-		r.wroteHeader = true
+// rewriteHostInURL parses raw as a URL and, if its hostname matches a
+// replacement, rewrites just the hostname, preserving scheme, port and
+// path. Values that fail to parse or don't match are returned unchanged.
+func rewriteHostInURL(raw string, replacements []DomainReplacement, psl PublicSuffixList) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+
+	_, _, replaced, ok := matchDomain(u.Hostname(), replacements, psl)
+	if !ok {
+		return raw
+	}
+
+	if port := u.Port(); port != "" {
+		u.Host = replaced + ":" + port
+	} else {
+		u.Host = replaced
 	}
-	return r.ResponseWriter.Write(b)
+	return u.String()
 }
 
-// rewriteCookieDomains modifies all Set-Cookie headers according to replacement rules
-func (r *responseWriter) rewriteCookieDomains() {
-	cookies := r.Header().Values("Set-Cookie")
+// rewriteCookieHeader rewrites every Set-Cookie entry in header in place.
+func rewriteCookieHeader(header http.Header, requestHost string, replacements []DomainReplacement, psl PublicSuffixList, obs observer) {
+	cookies := header.Values("Set-Cookie")
 	if len(cookies) == 0 {
 		return
 	}
 
-	// Remove existing Set-Cookie headers
-	r.Header().Del("Set-Cookie")
-
-	// Process and re-add each cookie with domain replacement
+	header.Del("Set-Cookie")
 	for _, cookie := range cookies {
-		modified := cookie
-		
-		for _, replacement := range r.replacements {
-			// Case-insensitive domain replacement
-			// Handle "Domain=example.com" (capital D)
-			modified = strings.ReplaceAll(modified,
-				fmt.Sprintf("Domain=%s", replacement.From),
-				fmt.Sprintf("Domain=%s", replacement.To))
-			
-			// Handle "domain=example.com" (lowercase d)
-			modified = strings.ReplaceAll(modified,
-				fmt.Sprintf("domain=%s", replacement.From),
-				fmt.Sprintf("domain=%s", replacement.To))
-		}
-		
-		r.Header().Add("Set-Cookie", modified)
+		header.Add("Set-Cookie", rewriteSetCookie(cookie, requestHost, replacements, psl, obs))
+	}
+}
+
+// cookieAttribute is a single Set-Cookie attribute, e.g. "Domain=example.com"
+// or the valueless "Secure"/"HttpOnly" flags.
+type cookieAttribute struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+// String renders the attribute back to its "key=value" (or bare "key") form.
+func (a cookieAttribute) String() string {
+	if !a.hasValue {
+		return a.key
+	}
+	return a.key + "=" + a.value
+}
+
+// splitSetCookie splits a raw Set-Cookie header value into its leading
+// "name=value" pair and the list of attributes that follow it, per RFC 6265
+// §4.1.1. The name=value pair is returned verbatim so that quoted or
+// otherwise unusual cookie values are preserved untouched.
+func splitSetCookie(raw string) (nameValue string, attrs []cookieAttribute) {
+	parts := strings.Split(raw, ";")
+	nameValue = strings.TrimSpace(parts[0])
+
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if idx := strings.IndexByte(part, '='); idx >= 0 {
+			attrs = append(attrs, cookieAttribute{
+				key:      strings.TrimSpace(part[:idx]),
+				value:    strings.TrimSpace(part[idx+1:]),
+				hasValue: true,
+			})
+			continue
+		}
+		attrs = append(attrs, cookieAttribute{key: part})
+	}
+
+	return nameValue, attrs
+}
+
+// rewriteSetCookie parses a single Set-Cookie header value and rewrites its
+// Domain attribute according to replacements, leaving every other attribute
+// (SameSite, Priority, Partitioned, Secure, Path, ...) untouched by default.
+// If the cookie has no explicit Domain attribute, it implicitly scopes to
+// requestHost (RFC 6265 §5.3 step 6); when requestHost itself matches a
+// replacement, an explicit Domain attribute is added so the cookie keeps
+// working once the browser is pointed at the replacement host.
+//
+// Once a rule matches the cookie's Domain, its PathFrom/PathTo, SameSite and
+// StripSecure settings are applied to the same cookie so everything needed
+// to move it between environments happens together.
+func rewriteSetCookie(raw, requestHost string, replacements []DomainReplacement, psl PublicSuffixList, obs observer) string {
+	nameValue, attrs := splitSetCookie(raw)
+	cookieName := cookieNameOf(nameValue)
+
+	var matched *DomainReplacement
+	foundDomain := false
+	for i, attr := range attrs {
+		if !attr.hasValue || !strings.EqualFold(attr.key, "domain") {
+			continue
+		}
+		foundDomain = true
+		if idx, rule, replaced, ok := matchDomain(attr.value, replacements, psl); ok {
+			obs.rewritten(requestHost, cookieName, attr.value, replaced, idx)
+			attrs[i].value = replaced
+			matched = rule
+		}
+	}
+
+	if !foundDomain && requestHost != "" {
+		if idx, rule, replaced, ok := matchDomain(requestHost, replacements, psl); ok {
+			obs.rewritten(requestHost, cookieName, requestHost, replaced, idx)
+			attrs = append(attrs, cookieAttribute{key: "Domain", value: replaced, hasValue: true})
+			matched = rule
+		}
+	}
+
+	if matched == nil {
+		obs.skipped("no_matching_rule")
+	} else {
+		rewritePath(attrs, matched)
+		attrs = rewriteSameSite(attrs, matched)
+		attrs = stripSecureFlag(attrs, matched)
+	}
+
+	parts := make([]string, 0, len(attrs)+1)
+	parts = append(parts, nameValue)
+	for _, attr := range attrs {
+		parts = append(parts, attr.String())
+	}
+	return strings.Join(parts, "; ")
+}
+
+// cookieNameOf returns the cookie name from a Set-Cookie "name=value" pair.
+func cookieNameOf(nameValue string) string {
+	if idx := strings.IndexByte(nameValue, '='); idx >= 0 {
+		return nameValue[:idx]
+	}
+	return nameValue
+}
+
+// matchDomain finds the first replacement that applies to domain, trying an
+// exact match against From first (case-insensitively and ignoring a leading
+// dot, since RFC 6265 §4.1.2.3 makes "example.com" and ".example.com"
+// equivalent cookie-domains) and then, for rules with FromSuffix set, a
+// registrable-domain match via psl. It returns the matched rule's index and
+// pointer, plus the rewritten domain value with the leading dot restored if
+// the original had one.
+func matchDomain(domain string, replacements []DomainReplacement, psl PublicSuffixList) (index int, rule *DomainReplacement, replaced string, ok bool) {
+	hadDot := strings.HasPrefix(domain, ".")
+	bare := strings.TrimPrefix(domain, ".")
+
+	for i := range replacements {
+		if replacements[i].From == "" || !strings.EqualFold(bare, replacements[i].From) {
+			continue
+		}
+		if hadDot {
+			return i, &replacements[i], "." + replacements[i].To, true
+		}
+		return i, &replacements[i], replacements[i].To, true
+	}
+
+	for i := range replacements {
+		if replacements[i].From != "" || replacements[i].FromSuffix == "" {
+			continue
+		}
+		reg := registrableDomain(bare, psl)
+		if !strings.EqualFold(reg, replacements[i].FromSuffix) {
+			continue
+		}
+		newDomain := replacements[i].ToSuffix
+		if len(bare) > len(reg) {
+			newDomain = bare[:len(bare)-len(reg)] + replacements[i].ToSuffix
+		}
+		if hadDot {
+			return i, &replacements[i], "." + newDomain, true
+		}
+		return i, &replacements[i], newDomain, true
+	}
+
+	return -1, nil, "", false
+}
+
+// rewritePath swaps the rule's PathFrom prefix for PathTo on the cookie's
+// Path attribute, mirroring how Domain rewriting swaps From for To. It only
+// touches a Path that falls under PathFrom at a path-segment boundary, so
+// PathFrom "/api" does not also match "/apikey".
+func rewritePath(attrs []cookieAttribute, rule *DomainReplacement) {
+	if rule.PathFrom == "" || rule.PathTo == "" {
+		return
+	}
+	for i, attr := range attrs {
+		if !attr.hasValue || !strings.EqualFold(attr.key, "path") {
+			continue
+		}
+		if !hasPathPrefix(attr.value, rule.PathFrom) {
+			continue
+		}
+		attrs[i].value = path.Join(rule.PathTo, strings.TrimPrefix(attr.value, rule.PathFrom))
+	}
+}
+
+// hasPathPrefix reports whether p starts with prefix at a path-segment
+// boundary.
+func hasPathPrefix(p, prefix string) bool {
+	if !strings.HasPrefix(p, prefix) {
+		return false
+	}
+	return len(p) == len(prefix) || p[len(prefix)] == '/'
+}
+
+// rewriteSameSite overwrites (or adds) the cookie's SameSite attribute with
+// rule.SameSite, if set.
+func rewriteSameSite(attrs []cookieAttribute, rule *DomainReplacement) []cookieAttribute {
+	if rule.SameSite == "" {
+		return attrs
+	}
+	for i, attr := range attrs {
+		if attr.hasValue && strings.EqualFold(attr.key, "samesite") {
+			attrs[i].value = rule.SameSite
+			return attrs
+		}
+	}
+	return append(attrs, cookieAttribute{key: "SameSite", value: rule.SameSite, hasValue: true})
+}
+
+// stripSecureFlag removes the valueless Secure flag when rule.StripSecure is
+// set.
+func stripSecureFlag(attrs []cookieAttribute, rule *DomainReplacement) []cookieAttribute {
+	if !rule.StripSecure {
+		return attrs
+	}
+	kept := attrs[:0]
+	for _, attr := range attrs {
+		if !attr.hasValue && strings.EqualFold(attr.key, "secure") {
+			continue
+		}
+		kept = append(kept, attr)
 	}
+	return kept
 }
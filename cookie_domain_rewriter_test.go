@@ -1,9 +1,11 @@
-package cookie_domain_rewriter_test
+package cookie_domain_rewrite_test
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	plugin "github.com/bmeyer-orm/cookie-domain-rewrite"
@@ -114,6 +116,138 @@ func TestCookieDomainRewriter(t *testing.T) {
 			expectedCookie: "session=abc123; Domain=oreilly.review; Secure",
 			shouldRewrite:  false,
 		},
+		{
+			name: "cookie value containing the domain text is left alone",
+			config: &plugin.Config{
+				MatchDomains: []string{"*.local"},
+				Replacements: []plugin.DomainReplacement{
+					{From: "oreilly.review", To: "oreilly.local"},
+				},
+			},
+			requestHost:    "api.oreilly.local",
+			setCookie:      "redirect=Domain%3Doreilly.review; Domain=oreilly.review; Secure",
+			expectedCookie: "redirect=Domain%3Doreilly.review; Domain=oreilly.local; Secure",
+			shouldRewrite:  true,
+		},
+		{
+			name: "leading dot is preserved across the rewrite",
+			config: &plugin.Config{
+				MatchDomains: []string{"*.local"},
+				Replacements: []plugin.DomainReplacement{
+					{From: "oreilly.review", To: "oreilly.local"},
+				},
+			},
+			requestHost:    "api.oreilly.local",
+			setCookie:      "session=abc123; Domain=.oreilly.review; Secure",
+			expectedCookie: "session=abc123; Domain=.oreilly.local; Secure",
+			shouldRewrite:  true,
+		},
+		{
+			name: "whitespace around the domain attribute is normalized",
+			config: &plugin.Config{
+				MatchDomains: []string{"*.local"},
+				Replacements: []plugin.DomainReplacement{
+					{From: "oreilly.review", To: "oreilly.local"},
+				},
+			},
+			requestHost:    "api.oreilly.local",
+			setCookie:      "session=abc123;  domain = oreilly.review ; Secure",
+			expectedCookie: "session=abc123; domain=oreilly.local; Secure",
+			shouldRewrite:  true,
+		},
+		{
+			name: "unknown attributes and quoted values are preserved",
+			config: &plugin.Config{
+				MatchDomains: []string{"*.local"},
+				Replacements: []plugin.DomainReplacement{
+					{From: "oreilly.review", To: "oreilly.local"},
+				},
+			},
+			requestHost:    "api.oreilly.local",
+			setCookie:      `session="abc 123"; Domain=oreilly.review; SameSite=None; Priority=High; Partitioned`,
+			expectedCookie: `session="abc 123"; Domain=oreilly.local; SameSite=None; Priority=High; Partitioned`,
+			shouldRewrite:  true,
+		},
+		{
+			name: "absent domain attribute defaults to the request host",
+			config: &plugin.Config{
+				MatchDomains: []string{"*.local"},
+				Replacements: []plugin.DomainReplacement{
+					{From: "api.oreilly.review", To: "api.oreilly.local"},
+				},
+			},
+			requestHost:    "api.oreilly.review",
+			requestOrigin:  "https://www.oreilly.local",
+			setCookie:      "session=abc123; Secure; HttpOnly",
+			expectedCookie: "session=abc123; Secure; HttpOnly; Domain=api.oreilly.local",
+			shouldRewrite:  true,
+		},
+		{
+			name: "path prefix is rewritten alongside the domain",
+			config: &plugin.Config{
+				MatchDomains: []string{"*.local"},
+				Replacements: []plugin.DomainReplacement{
+					{From: "oreilly.review", To: "oreilly.local", PathFrom: "/api", PathTo: "/"},
+				},
+			},
+			requestHost:    "api.oreilly.local",
+			setCookie:      "session=abc123; Domain=oreilly.review; Path=/api/v1; Secure",
+			expectedCookie: "session=abc123; Domain=oreilly.local; Path=/v1; Secure",
+			shouldRewrite:  true,
+		},
+		{
+			name: "path outside the configured prefix is left alone",
+			config: &plugin.Config{
+				MatchDomains: []string{"*.local"},
+				Replacements: []plugin.DomainReplacement{
+					{From: "oreilly.review", To: "oreilly.local", PathFrom: "/api", PathTo: "/"},
+				},
+			},
+			requestHost:    "api.oreilly.local",
+			setCookie:      "session=abc123; Domain=oreilly.review; Path=/apikey; Secure",
+			expectedCookie: "session=abc123; Domain=oreilly.local; Path=/apikey; Secure",
+			shouldRewrite:  true,
+		},
+		{
+			name: "SameSite is downgraded and Secure stripped for a dev target",
+			config: &plugin.Config{
+				MatchDomains: []string{"*.local"},
+				Replacements: []plugin.DomainReplacement{
+					{From: "oreilly.review", To: "oreilly.local", SameSite: "Lax", StripSecure: true},
+				},
+			},
+			requestHost:    "api.oreilly.local",
+			setCookie:      "session=abc123; Domain=oreilly.review; SameSite=None; Secure",
+			expectedCookie: "session=abc123; Domain=oreilly.local; SameSite=Lax",
+			shouldRewrite:  true,
+		},
+		{
+			name: "FromSuffix rewrites by registrable domain and keeps the subdomain label",
+			config: &plugin.Config{
+				MatchDomains: []string{"*.local"},
+				Replacements: []plugin.DomainReplacement{
+					{FromSuffix: "oreilly.review", ToSuffix: "oreilly.local"},
+				},
+			},
+			requestHost:    "api.oreilly.local",
+			setCookie:      "session=abc123; Domain=api.oreilly.review; Secure",
+			expectedCookie: "session=abc123; Domain=api.oreilly.local; Secure",
+			shouldRewrite:  true,
+		},
+		{
+			name: "MatchRegistrableDomain matches subdomains without a wildcard",
+			config: &plugin.Config{
+				MatchDomains:           []string{"oreilly.local"},
+				MatchRegistrableDomain: true,
+				Replacements: []plugin.DomainReplacement{
+					{From: "oreilly.review", To: "oreilly.local"},
+				},
+			},
+			requestHost:    "api.oreilly.local",
+			setCookie:      "session=abc123; Domain=oreilly.review; Secure",
+			expectedCookie: "session=abc123; Domain=oreilly.local; Secure",
+			shouldRewrite:  true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -198,3 +332,176 @@ func TestMultipleSetCookieHeaders(t *testing.T) {
 		}
 	}
 }
+
+func TestRewriteRequest(t *testing.T) {
+	config := &plugin.Config{
+		MatchDomains: []string{"*.local"},
+		Replacements: []plugin.DomainReplacement{
+			{From: "api.oreilly.review", To: "api.oreilly.local"},
+		},
+		RewriteRequest: true,
+	}
+
+	var gotHost, gotOrigin, gotReferer string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+		gotOrigin = r.Header.Get("Origin")
+		gotReferer = r.Header.Get("Referer")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler, err := plugin.New(context.Background(), next, config, "cookie-domain-rewriter")
+	if err != nil {
+		t.Fatalf("failed to create plugin: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/test", nil)
+	req.Host = "api.oreilly.local:8443"
+	req.Header.Set("Origin", "https://api.oreilly.local")
+	req.Header.Set("Referer", "https://api.oreilly.local/dashboard")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if gotHost != "api.oreilly.review:8443" {
+		t.Errorf("Host mismatch:\ngot:  %s\nwant: %s", gotHost, "api.oreilly.review:8443")
+	}
+	if gotOrigin != "https://api.oreilly.review" {
+		t.Errorf("Origin mismatch:\ngot:  %s\nwant: %s", gotOrigin, "https://api.oreilly.review")
+	}
+	if gotReferer != "https://api.oreilly.review/dashboard" {
+		t.Errorf("Referer mismatch:\ngot:  %s\nwant: %s", gotReferer, "https://api.oreilly.review/dashboard")
+	}
+}
+
+func TestBufferedResponseWriter(t *testing.T) {
+	config := &plugin.Config{
+		MatchDomains: []string{"*.local"},
+		Replacements: []plugin.DomainReplacement{
+			{From: "oreilly.review", To: "oreilly.local"},
+		},
+		Buffered: true,
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A handler that writes its body before it's done setting
+		// headers - only a buffered writer can still rewrite this.
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("hello"))
+		w.Header().Add("Set-Cookie", "session=abc123; Domain=oreilly.review; Secure")
+	})
+
+	handler, err := plugin.New(context.Background(), next, config, "cookie-domain-rewriter")
+	if err != nil {
+		t.Fatalf("failed to create plugin: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/test", nil)
+	req.Host = "api.oreilly.local"
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusAccepted {
+		t.Errorf("status code mismatch: got %d want %d", recorder.Code, http.StatusAccepted)
+	}
+	if recorder.Body.String() != "hello" {
+		t.Errorf("body mismatch: got %q want %q", recorder.Body.String(), "hello")
+	}
+
+	want := "session=abc123; Domain=oreilly.local; Secure"
+	if got := recorder.Header().Get("Set-Cookie"); got != want {
+		t.Errorf("Set-Cookie header mismatch:\ngot:  %s\nwant: %s", got, want)
+	}
+}
+
+// recordingHandler is a minimal slog.Handler that captures formatted log
+// lines so tests can assert on them without a real logging backend.
+type recordingHandler struct {
+	lines *[]string
+}
+
+func (h recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	r.Attrs(func(a slog.Attr) bool {
+		b.WriteString(" ")
+		b.WriteString(a.Key)
+		b.WriteString("=")
+		b.WriteString(a.Value.String())
+		return true
+	})
+	*h.lines = append(*h.lines, b.String())
+	return nil
+}
+
+func (h recordingHandler) WithAttrs(attrs []slog.Attr) slog.Handler { return h }
+func (h recordingHandler) WithGroup(name string) slog.Handler       { return h }
+
+// fakeCounter and fakeCounterVec implement plugin.Counter/CounterVec so
+// tests can assert on which label combinations got incremented, without
+// depending on the Prometheus client library.
+type fakeCounter struct {
+	vec    *fakeCounterVec
+	labels []string
+}
+
+func (c *fakeCounter) Inc() { c.vec.incs = append(c.vec.incs, c.labels) }
+
+type fakeCounterVec struct {
+	incs [][]string
+}
+
+func (v *fakeCounterVec) WithLabelValues(lvs ...string) plugin.Counter {
+	return &fakeCounter{vec: v, labels: append([]string(nil), lvs...)}
+}
+
+func TestObservability(t *testing.T) {
+	var lines []string
+	logger := slog.New(recordingHandler{lines: &lines})
+	rewrites := &fakeCounterVec{}
+	skips := &fakeCounterVec{}
+
+	config := &plugin.Config{
+		MatchDomains: []string{"*.local"},
+		Replacements: []plugin.DomainReplacement{
+			{From: "oreilly.review", To: "oreilly.local"},
+		},
+		Logger:         logger,
+		RewriteCounter: rewrites,
+		SkipCounter:    skips,
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Set-Cookie", "session=abc123; Domain=oreilly.review; Secure")
+		w.Header().Add("Set-Cookie", "tracking=xyz; Domain=other.com; Secure")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler, err := plugin.New(context.Background(), next, config, "cookie-domain-rewriter")
+	if err != nil {
+		t.Fatalf("failed to create plugin: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/test", nil)
+	req.Host = "api.oreilly.local"
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 log line, got %d: %v", len(lines), lines)
+	}
+	for _, want := range []string{"cookie_name=session", "from_domain=oreilly.review", "to_domain=oreilly.local", "rule_index=0"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("log line missing %q: %s", want, lines[0])
+		}
+	}
+
+	if len(rewrites.incs) != 1 || rewrites.incs[0][1] != "oreilly.review" || rewrites.incs[0][2] != "oreilly.local" {
+		t.Errorf("unexpected rewrite counter increments: %v", rewrites.incs)
+	}
+	if len(skips.incs) != 1 || skips.incs[0][0] != "no_matching_rule" {
+		t.Errorf("unexpected skip counter increments: %v", skips.incs)
+	}
+}
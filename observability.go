@@ -0,0 +1,56 @@
+package cookie_domain_rewrite
+
+import (
+	"log/slog"
+	"strconv"
+)
+
+// Counter is the minimal interface this plugin needs to report a single
+// counter value, matching prometheus.Counter's Inc method.
+type Counter interface {
+	Inc()
+}
+
+// CounterVec is the minimal interface this plugin needs to report a labeled
+// counter, matching prometheus.CounterVec's WithLabelValues method. Passing
+// in the real thing from github.com/prometheus/client_golang/prometheus lets
+// operators expose cookie_rewrites_total and cookie_rewrite_skipped_total
+// without this plugin depending on that library directly.
+type CounterVec interface {
+	WithLabelValues(lvs ...string) Counter
+}
+
+// observer reports what happened to each cookie this middleware processes:
+// a debug-level structured log line per rewrite, plus optional counters. Its
+// zero value reports nothing, so a Config that sets none of Logger,
+// RewriteCounter or SkipCounter costs nothing.
+type observer struct {
+	logger         *slog.Logger
+	rewriteCounter CounterVec
+	skipCounter    CounterVec
+}
+
+// rewritten logs and counts a cookie whose Domain attribute was rewritten by
+// replacements[ruleIndex].
+func (o observer) rewritten(requestHost, cookieName, fromDomain, toDomain string, ruleIndex int) {
+	if o.logger != nil {
+		o.logger.Debug("cookie domain rewritten",
+			"request_host", requestHost,
+			"cookie_name", cookieName,
+			"from_domain", fromDomain,
+			"to_domain", toDomain,
+			"rule_index", ruleIndex,
+		)
+	}
+	if o.rewriteCounter != nil {
+		o.rewriteCounter.WithLabelValues(strconv.Itoa(ruleIndex), fromDomain, toDomain).Inc()
+	}
+}
+
+// skipped counts a cookie this middleware left untouched, e.g. because no
+// rule matched its Domain.
+func (o observer) skipped(reason string) {
+	if o.skipCounter != nil {
+		o.skipCounter.WithLabelValues(reason).Inc()
+	}
+}
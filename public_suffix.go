@@ -0,0 +1,66 @@
+package cookie_domain_rewrite
+
+import "strings"
+
+// PublicSuffixList computes the public suffix of a domain. It matches the
+// interface implemented by golang.org/x/net/publicsuffix.List, so callers
+// that already depend on that package can plug it in via
+// Config.PublicSuffixList without an adapter.
+type PublicSuffixList interface {
+	PublicSuffix(domain string) string
+}
+
+// defaultPublicSuffixList is the built-in PublicSuffixList used when
+// Config.PublicSuffixList is nil. It only special-cases the small set of
+// multi-label public suffixes operators actually run dev proxies against;
+// everything else falls back to treating the domain's last label as the
+// suffix. This keeps the plugin self-contained with no external PSL data
+// file, at the cost of not covering the full Mozilla public suffix list.
+type defaultPublicSuffixList struct{}
+
+// multiLabelSuffixes are the public suffixes defaultPublicSuffixList
+// recognizes that span more than one label.
+var multiLabelSuffixes = map[string]bool{
+	"co.uk":     true,
+	"org.uk":    true,
+	"gov.uk":    true,
+	"com.au":    true,
+	"com.br":    true,
+	"co.jp":     true,
+	"co.nz":     true,
+	"co.in":     true,
+	"github.io": true,
+}
+
+// PublicSuffix implements PublicSuffixList.
+func (defaultPublicSuffixList) PublicSuffix(domain string) string {
+	labels := strings.Split(domain, ".")
+	if len(labels) == 0 {
+		return domain
+	}
+	if len(labels) >= 3 {
+		candidate := strings.Join(labels[len(labels)-2:], ".")
+		if multiLabelSuffixes[candidate] {
+			return candidate
+		}
+	}
+	return labels[len(labels)-1]
+}
+
+// registrableDomain computes the registrable domain (eTLD+1) of host using
+// psl, mirroring the jarKey algorithm net/http/cookiejar uses to group
+// cookies by registrable domain.
+func registrableDomain(host string, psl PublicSuffixList) string {
+	suffix := psl.PublicSuffix(host)
+	if suffix == host || len(suffix) >= len(host) {
+		return host
+	}
+
+	i := len(host) - len(suffix) - 1
+	if i <= 0 || host[i] != '.' {
+		return host
+	}
+
+	prevDot := strings.LastIndex(host[:i], ".")
+	return host[prevDot+1:]
+}
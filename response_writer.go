@@ -0,0 +1,135 @@
+package cookie_domain_rewrite
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// responseWriter wraps http.ResponseWriter to rewrite Set-Cookie headers as
+// soon as the wrapped handler writes them, passing everything else (status
+// code, body, Hijack/Flush) straight through to rw.
+type responseWriter struct {
+	http.ResponseWriter
+	replacements     []DomainReplacement
+	requestHost      string
+	publicSuffixList PublicSuffixList
+	observer         observer
+	wroteHeader      bool
+}
+
+// WriteHeader intercepts the header write to modify Set-Cookie headers
+func (r *responseWriter) WriteHeader(statusCode int) {
+	if !r.wroteHeader {
+		rewriteCookieHeader(r.Header(), r.requestHost, r.replacements, r.publicSuffixList, r.observer)
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write ensures headers are written before body
+func (r *responseWriter) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		rewriteCookieHeader(r.Header(), r.requestHost, r.replacements, r.publicSuffixList, r.observer)
+		r.wroteHeader = true
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher by delegating to the wrapped ResponseWriter,
+// if it supports it.
+func (r *responseWriter) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker by delegating to the wrapped
+// ResponseWriter, if it supports it.
+func (r *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying ResponseWriter does not implement http.Hijacker")
+	}
+	return hijacker.Hijack()
+}
+
+// CloseNotify implements the deprecated http.CloseNotifier by delegating to
+// the wrapped ResponseWriter, if it supports it.
+func (r *responseWriter) CloseNotify() <-chan bool {
+	if notifier, ok := r.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// bufferedResponseWriter captures the full response (status, headers, body)
+// instead of writing it straight through, so Set-Cookie rewriting happens
+// against whatever header state the wrapped handler finally settles on -
+// including any rewrites a middleware chained after this one makes - rather
+// than whatever was present at the first WriteHeader/Write call. Nothing
+// reaches the real http.ResponseWriter until finalize runs.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	replacements     []DomainReplacement
+	requestHost      string
+	publicSuffixList PublicSuffixList
+	observer         observer
+	statusCode       int
+	body             bytes.Buffer
+	wroteHeader      bool
+}
+
+// WriteHeader records the status code without writing it through yet.
+func (b *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if !b.wroteHeader {
+		b.statusCode = statusCode
+		b.wroteHeader = true
+	}
+}
+
+// Write buffers the body without writing it through yet.
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	if !b.wroteHeader {
+		b.WriteHeader(http.StatusOK)
+	}
+	return b.body.Write(p)
+}
+
+// Flush is a no-op: buffering the whole response is incompatible with
+// streaming partial output, so there is nothing to flush until finalize.
+func (b *bufferedResponseWriter) Flush() {}
+
+// Hijack delegates straight to the wrapped ResponseWriter, abandoning
+// buffering - a caller that hijacks the connection takes over raw I/O
+// itself, so nothing captured so far would ever be written through anyway.
+func (b *bufferedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := b.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying ResponseWriter does not implement http.Hijacker")
+	}
+	return hijacker.Hijack()
+}
+
+// CloseNotify implements the deprecated http.CloseNotifier by delegating to
+// the wrapped ResponseWriter, if it supports it.
+func (b *bufferedResponseWriter) CloseNotify() <-chan bool {
+	if notifier, ok := b.ResponseWriter.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// finalize rewrites Set-Cookie headers against the final header state, then
+// writes the captured status code and body to the real ResponseWriter.
+func (b *bufferedResponseWriter) finalize() {
+	rewriteCookieHeader(b.Header(), b.requestHost, b.replacements, b.publicSuffixList, b.observer)
+
+	if !b.wroteHeader {
+		b.statusCode = http.StatusOK
+	}
+	b.ResponseWriter.WriteHeader(b.statusCode)
+	b.ResponseWriter.Write(b.body.Bytes())
+}